@@ -1,6 +1,34 @@
-// Command jsonpatch applies RFC 6902 JSON Patches to JSON or YAML documents.
+// Command jsonpatch applies RFC 6902 JSON Patches or RFC 7396 JSON Merge Patches to JSON or YAML documents.
 //
-// If at least one document is provided, the patch file is parsed as a RFC 6902 JSON Patch.
+// If at least one document is provided, the patch file is parsed as a RFC 6902 JSON Patch or a RFC 7396
+// JSON Merge Patch. The format is auto-detected from the patch file content (a JSON array is a JSON Patch;
+// a JSON object is a Merge Patch), or it can be forced with -format. With -strategic, it is parsed as
+// a Kubernetes strategic merge patch instead.
+//
+// A document given as "-" is read from stdin and the patched result is written to stdout, so
+// jsonpatch can be used in a shell pipeline. -i/-in-place rewrites documents atomically in place
+// instead of writing to -outdir.
+//
+// A RFC 6902 JSON Patch applied to a YAML document is applied directly to the YAML AST, leaving
+// comments, key order, anchors, and multi-document ("---"-separated) streams intact. By default
+// the patch is applied to every document in the stream; -doc selects a single one. Only the add,
+// remove, replace, and test operations are supported on the YAML AST; move and copy fail there.
+//
+// When applying a RFC 6902 JSON Patch, jsonpatch also looks for a sibling overlay file next to
+// each document (e.g. "foo.yaml.local" next to "foo.yaml") and, if present, merges it onto the
+// document as a RFC 7396 JSON Merge Patch before the patch runs. -overlay-suffix changes the
+// suffix, or disables the convention if set to "". A YAML document with an overlay is converted
+// to JSON and back rather than patched on its AST, so its comments, key order, and anchors are
+// not kept, and a multi-document stream is rejected. In a batch patch file, sibling overlays are
+// not looked up; an entry's overlayGlob declares them instead.
+//
+// -schema validates each patched document against a JSON Schema before it's written, failing with
+// a JSON-Pointer error if it doesn't conform. -openapi plus -kind validates against a named
+// definition in a Swagger/OpenAPI document instead, e.g. the one `kubectl` ships with, giving CI
+// pipelines a local stand-in for `kubectl --dry-run=server`.
+//
+// jsonpatch diff <from> <to> generates a patch that transforms <from> into <to>; run
+// `jsonpatch diff -help` for details.
 //
 // If no documents are provided, the patch file is parsed as a batch patch file:
 //
@@ -17,6 +45,29 @@
 //				{ "op": "test", "path": "/b", "value": 1 },
 //				{ "op": "remove", "path": "/b" }
 //			]
+//		},
+//		{
+//			"glob": "*.merge.json"
+//			"mergePatch": { "a": 1, "b": null }
+//		},
+//		{
+//			"glob": "deployment.yaml"
+//			"strategicPatch": { "spec": { "template": { "spec": { "containers": [ { "name": "app", "image": "app:v2" } ] } } } }
+//			"target": { "group": "apps", "version": "v1", "kind": "Deployment" }
+//		},
+//		{
+//			"glob": "config/*.yaml"
+//			"overlayGlob": "config/*.yaml.local"
+//			"jsonPatch": [
+//				{ "op": "add", "path": "/debug", "value": true }
+//			]
+//		},
+//		{
+//			"glob": "deployment.yaml"
+//			"schema": "deployment.schema.json"
+//			"jsonPatch": [
+//				{ "op": "replace", "path": "/spec/replicas", "value": 3 }
+//			]
 //		}
 //	]
 package main
@@ -30,56 +81,202 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
 	yaml "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 )
 
 var usage = `usage: jsonpatch <patch file> [<documents>]
 
-jsonpatch applies RFC 6902 JSON Patches to JSON or YAML documents.
+jsonpatch applies RFC 6902 JSON Patches or RFC 7396 JSON Merge Patches to JSON or YAML documents.
+
+If at least one document is provided, the patch file is parsed as a RFC 6902 JSON Patch or a
+RFC 7396 JSON Merge Patch. The format is auto-detected from the patch file content (a JSON array
+is a JSON Patch; a JSON object is a Merge Patch), or it can be forced with -format. With
+-strategic, it is parsed as a Kubernetes strategic merge patch instead.
+
+A document given as "-" is read from stdin and the patched result is written to stdout, so
+jsonpatch can be used in a shell pipeline, e.g.
+
+	kubectl get -o yaml deploy/web | jsonpatch patch.yaml - | kubectl apply -f -
+
+-i/-in-place rewrites documents atomically in place instead of writing to -outdir.
+
+A RFC 6902 JSON Patch applied to a YAML document is applied directly to the YAML AST, leaving
+comments, key order, anchors, and multi-document ("---"-separated) streams intact. By default
+the patch is applied to every document in the stream; -doc selects a single one. Only the add,
+remove, replace, and test operations are supported on the YAML AST; move and copy fail there.
+
+When applying a RFC 6902 JSON Patch, jsonpatch also looks for a sibling overlay file next to each
+document (e.g. "foo.yaml.local" next to "foo.yaml") and, if present, merges it onto the document
+as a RFC 7396 JSON Merge Patch before the patch runs. -overlay-suffix changes the suffix, or
+disables the convention if set to "". A YAML document with an overlay is converted to JSON and
+back rather than patched on its AST, so its comments, key order, and anchors are not kept, and a
+multi-document stream is rejected. In a batch patch file, sibling overlays are not looked up; an
+entry's overlayGlob declares them instead.
+
+-schema validates each patched document against a JSON Schema before it's written, failing with a
+JSON-Pointer error if it doesn't conform. -openapi plus -kind validates against a named definition
+in a Swagger/OpenAPI document instead, e.g. the one kubectl ships with, giving CI pipelines a local
+stand-in for "kubectl --dry-run=server".
 
-If at least one document is provided, the patch file is parsed as a RFC 6902 JSON Patch.
+jsonpatch diff <from> <to> generates a patch that transforms <from> into <to>; run
+"jsonpatch diff -help" for details.
 
 If no documents are provided, the patch file is parsed as a batch patch file:
 
 [
 	{
-		"glob": "*.json" 
+		"glob": "*.json"
 		"jsonPatch": [
 			{ "op": "add", "path": "/a", "value": 1 }
 		]
 	},
 	{
-		"glob": "*.yaml" 
+		"glob": "*.yaml"
 		"jsonPatch": [
 			{ "op": "test", "path": "/b", "value": 1 },
 			{ "op": "remove", "path": "/b" }
 		]
+	},
+	{
+		"glob": "*.merge.json"
+		"mergePatch": { "a": 1, "b": null }
+	},
+	{
+		"glob": "deployment.yaml"
+		"strategicPatch": { "spec": { "template": { "spec": { "containers": [ { "name": "app", "image": "app:v2" } ] } } } }
+		"target": { "group": "apps", "version": "v1", "kind": "Deployment" }
+	},
+	{
+		"glob": "config/*.yaml"
+		"overlayGlob": "config/*.yaml.local"
+		"jsonPatch": [
+			{ "op": "add", "path": "/debug", "value": true }
+		]
+	},
+	{
+		"glob": "deployment.yaml"
+		"schema": "deployment.schema.json"
+		"jsonPatch": [
+			{ "op": "replace", "path": "/spec/replicas", "value": 3 }
+		]
 	}
 ]
 `
 
+const (
+	// formatPatch is a RFC 6902 JSON Patch: an array of operations.
+	formatPatch = "patch"
+
+	// formatMerge is a RFC 7396 JSON Merge Patch: an object merged into the document.
+	formatMerge = "merge"
+)
+
 type patch struct {
 	// Glob is glob pattern that determines which files the JSON Patch applies to.
 	Glob string `json:"glob"`
 
-	// Patch is a JSON Patch as defined in RFC 6902 from the IETF.
+	// JSONPatch is a JSON Patch as defined in RFC 6902 from the IETF.
 	JSONPatch json.RawMessage `json:"jsonPatch"`
+
+	// MergePatch is a JSON Merge Patch as defined in RFC 7396 from the IETF.
+	MergePatch json.RawMessage `json:"mergePatch"`
+
+	// StrategicPatch is a Kubernetes strategic merge patch.
+	StrategicPatch json.RawMessage `json:"strategicPatch"`
+
+	// Target identifies the apiVersion/kind that StrategicPatch is applied against.
+	// It is required when StrategicPatch is set.
+	Target *target `json:"target"`
+
+	// DocumentSelector selects a single document (0-indexed) to patch within a multi-document
+	// YAML stream. Only meaningful together with JSONPatch; nil applies the patch to every
+	// document in the stream.
+	DocumentSelector *int `json:"documentSelector"`
+
+	// OverlayGlob, when set, must equal Glob with a literal suffix appended (e.g. "config/*.yaml"
+	// and "config/*.yaml.local"). For each document matched by Glob, the overlay at that
+	// document's path plus the suffix is merged onto it, as a RFC 7396 JSON Merge Patch, before
+	// JSONPatch is applied, if present; a document with no corresponding overlay is left
+	// unmodified. Only meaningful together with JSONPatch.
+	OverlayGlob string `json:"overlayGlob"`
+
+	// Schema is the path to a JSON Schema file. Each document matched by Glob is validated
+	// against it after JSONPatch, MergePatch, or StrategicPatch is applied, before the result is
+	// written.
+	Schema string `json:"schema"`
+}
+
+// target identifies the Kubernetes API type (group/version/kind) that a strategic merge patch
+// is interpreted against.
+type target struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// schemaProvider resolves a target to the Go struct whose field tags (patchMergeKey,
+// patchStrategy) tell strategicpatch how to merge list fields like containers and volumes.
+type schemaProvider func(t target) (interface{}, error)
+
+// defaultSchemaProvider understands the built-in workload kinds most commonly patched with
+// strategic merge patches.
+func defaultSchemaProvider(t target) (interface{}, error) {
+	switch t.Kind {
+	case "Pod":
+		return &corev1.Pod{}, nil
+	case "Deployment":
+		return &appsv1.Deployment{}, nil
+	case "DaemonSet":
+		return &appsv1.DaemonSet{}, nil
+	case "StatefulSet":
+		return &appsv1.StatefulSet{}, nil
+	case "ReplicaSet":
+		return &appsv1.ReplicaSet{}, nil
+	default:
+		return nil, fmt.Errorf("no built-in schema for kind %q", t.Kind)
+	}
 }
 
 func main() {
-	os.Exit(testableMain(os.Args[1:], os.Stdout))
+	os.Exit(testableMain(os.Args[1:], os.Stdin, os.Stdout))
 }
 
-func testableMain(args []string, stdout io.Writer) int {
+func testableMain(args []string, stdin io.Reader, stdout io.Writer) int {
+	if len(args) > 0 && args[0] == "diff" {
+		return diffMain(args[1:], stdout)
+	}
+
 	flags := flag.NewFlagSet("jpatch", flag.ContinueOnError)
 	flags.SetOutput(stdout)
 	flags.Usage = func() {
 		fmt.Fprint(stdout, usage)
 	}
 	outdir := flags.String("outdir", ".", "the directory where patched documents are emitted")
+	format := flags.String("format", "", `the format of the patch file: "patch" for a RFC 6902 JSON Patch, "merge" for a RFC 7396 JSON Merge Patch (default: auto-detect)`)
+	strategic := flags.Bool("strategic", false, "apply the patch file as a Kubernetes strategic merge patch (requires -target-kind)")
+	targetGroup := flags.String("target-group", "", "the Kubernetes API group the strategic merge patch targets")
+	targetVersion := flags.String("target-version", "", "the Kubernetes API version the strategic merge patch targets")
+	targetKind := flags.String("target-kind", "", "the Kubernetes Kind (e.g. Pod, Deployment) the strategic merge patch targets")
+	inPlace := flags.Bool("i", false, `edit documents in place, atomically (via a temp file + rename), instead of writing to -outdir`)
+	flags.BoolVar(inPlace, "in-place", false, `alias for -i`)
+	docFormat := flags.String("doc-format", "", `the format ("json" or "yaml") of every document, overriding the file extension; a document given as "-" has no extension and is read as JSON unless this is "yaml"`)
+	docIndex := flags.Int("doc", -1, `apply the patch to only the N-th document (0-indexed) of a multi-document YAML stream (default: every document)`)
+	overlaySuffix := flags.String("overlay-suffix", ".local", `the suffix of a sibling overlay file (e.g. "foo.yaml" + ".local") merged onto a document as a JSON Merge Patch before a RFC 6902 JSON Patch runs; "" disables the convention`)
+	schemaPath := flags.String("schema", "", "validate each document against the JSON Schema in this file after the patch is applied, before it's written")
+	openapiPath := flags.String("openapi", "", "validate each document against a definition in this Swagger/OpenAPI document after the patch is applied, before it's written (requires -kind)")
+	schemaKind := flags.String("kind", "", `the Swagger/OpenAPI definition name (e.g. "Deployment") to validate against; requires -openapi`)
 	if err := flags.Parse(args); err != nil {
 		if err == flag.ErrHelp {
 			return 2
@@ -87,6 +284,25 @@ func testableMain(args []string, stdout io.Writer) int {
 		return 1
 	}
 
+	validate, err := resolveValidator(*schemaPath, *openapiPath, *schemaKind)
+	if err != nil {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+
+	opts := docOptions{
+		stdin:         stdin,
+		stdout:        stdout,
+		format:        *docFormat,
+		inPlace:       *inPlace,
+		outdir:        *outdir,
+		overlaySuffix: *overlaySuffix,
+		validate:      validate,
+	}
+	if *docIndex >= 0 {
+		opts.docSelector = docIndex
+	}
+
 	// Need at least one patch and one document.
 	switch flags.NArg() {
 	case 0:
@@ -101,7 +317,18 @@ func testableMain(args []string, stdout io.Writer) int {
 	default:
 		patch := flags.Arg(0)
 		documents := flags.Args()[1:]
-		if err := applySinglePatch(patch, documents, *outdir); err != nil {
+		if *strategic {
+			patchJSON, err := readJSON(patch)
+			if err != nil {
+				fmt.Fprintln(stdout, err)
+				return 1
+			}
+			t := target{Group: *targetGroup, Version: *targetVersion, Kind: *targetKind}
+			if err := applyStrategicPatch(patchJSON, documents, opts, t, defaultSchemaProvider); err != nil {
+				fmt.Fprintln(stdout, err)
+				return 1
+			}
+		} else if err := applySinglePatch(patch, documents, opts, *format); err != nil {
 			fmt.Fprintln(stdout, err)
 			return 1
 		}
@@ -127,126 +354,1248 @@ func applyBatchPatch(patchPath string, outdir string) error {
 		if err != nil {
 			return err
 		}
-		if err := applyJSONPatch([]byte(patch.JSONPatch), matches, outdir); err != nil {
-			return err
+		opts := docOptions{outdir: outdir, docSelector: patch.DocumentSelector}
+		if patch.OverlayGlob != "" {
+			opts.overlays, err = resolveOverlayGlob(matches, patch.Glob, patch.OverlayGlob)
+			if err != nil {
+				return err
+			}
+		}
+		if patch.Schema != "" {
+			schemaJSON, err := readJSON(patch.Schema)
+			if err != nil {
+				return err
+			}
+			opts.validate, err = newSchemaValidator(schemaJSON)
+			if err != nil {
+				return err
+			}
+		}
+		switch {
+		case patch.StrategicPatch != nil:
+			if patch.Target == nil {
+				return fmt.Errorf("batch entry for glob %q sets strategicPatch but not target", patch.Glob)
+			}
+			if err := applyStrategicPatch([]byte(patch.StrategicPatch), matches, opts, *patch.Target, defaultSchemaProvider); err != nil {
+				return err
+			}
+		case patch.MergePatch != nil:
+			if err := applyMergePatch([]byte(patch.MergePatch), matches, opts); err != nil {
+				return err
+			}
+		case patch.JSONPatch != nil:
+			if err := applyJSONPatch([]byte(patch.JSONPatch), matches, opts); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("batch entry for glob %q must set jsonPatch, mergePatch, or strategicPatch", patch.Glob)
 		}
 	}
 
 	return nil
 }
 
-func applySinglePatch(patchPath string, documentPaths []string, outdir string) error {
+func applySinglePatch(patchPath string, documentPaths []string, opts docOptions, format string) error {
 	patchJSON, err := readJSON(patchPath)
 	if err != nil {
 		return err
 	}
 
-	return applyJSONPatch(patchJSON, documentPaths, outdir)
+	if format == "" {
+		format = detectFormat(patchJSON)
+	}
+
+	switch format {
+	case formatMerge:
+		return applyMergePatch(patchJSON, documentPaths, opts)
+	case formatPatch:
+		return applyJSONPatch(patchJSON, documentPaths, opts)
+	default:
+		return fmt.Errorf("unknown -format %q: must be %q or %q", format, formatPatch, formatMerge)
+	}
+}
+
+// detectFormat guesses whether patchJSON is a RFC 6902 JSON Patch (a JSON array) or a
+// RFC 7396 JSON Merge Patch (anything else, typically a JSON object).
+func detectFormat(patchJSON []byte) string {
+	trimmed := bytes.TrimLeft(patchJSON, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return formatPatch
+	}
+	return formatMerge
 }
 
-func applyJSONPatch(patchJSON []byte, documentPaths []string, outdir string) error {
+func applyJSONPatch(patchJSON []byte, documentPaths []string, opts docOptions) error {
 	patch, err := jsonpatch.DecodePatch(patchJSON)
 	if err != nil {
 		return err
 	}
 
+	var ops []patchOp
+	if err := json.Unmarshal(patchJSON, &ops); err != nil {
+		return err
+	}
+
 	patchedDocs := map[string][]byte{}
 	for _, docPath := range documentPaths {
-		doc, err := readJSON(docPath)
+		overlay, hasOverlay, err := resolveOverlay(docPath, opts)
 		if err != nil {
 			return err
 		}
 
-		patchedDoc, err := patch.ApplyIndent(doc, "  ")
+		// A document with an overlay is routed through the conventional read-JSON/ApplyIndent
+		// path below, even when it's YAML: merging the overlay necessarily restructures the
+		// document, which is incompatible with applyJSONPatchYAML's job of preserving the
+		// original AST byte-for-byte wherever the patch doesn't touch it.
+		if !hasOverlay && isYAMLFormat(docPath, opts.format) {
+			patchedDoc, err := applyJSONPatchYAML(ops, docPath, opts)
+			if err != nil {
+				return errors.Wrapf(err, "error applying JSON Patch %s to %s", patchJSON, docPath)
+			}
+			patchedDocs[docPath] = patchedDoc
+			continue
+		}
+
+		doc, err := readDocJSON(docPath, opts)
 		if err != nil {
-			return errors.Wrapf(err, "error applying JSON Patch %s to %s", patchJSON, docPath)
+			return err
 		}
 
-		if yamlExt(docPath) {
-			var i interface{}
-			if err := json.Unmarshal(patchedDoc, &i); err != nil {
-				return err
-			}
-			y, err := yaml.Marshal(i)
+		if hasOverlay {
+			doc, err = applyOverlay(doc, overlay, docPath)
 			if err != nil {
 				return err
 			}
-			patchedDoc = y
+		}
+
+		patchedDoc, err := patch.ApplyIndent(doc, "  ")
+		if err != nil {
+			return errors.Wrapf(err, "error applying JSON Patch %s to %s", patchJSON, docPath)
+		}
+
+		if opts.validate != nil {
+			if err := opts.validate(patchedDoc); err != nil {
+				return errors.Wrapf(err, "patched %s failed schema validation", docPath)
+			}
+		}
+
+		patchedDoc, err = toOutputFormat(docPath, patchedDoc, opts.format)
+		if err != nil {
+			return err
 		}
 
 		patchedDocs[docPath] = patchedDoc
 	}
 
-	for path, doc := range patchedDocs {
-		outpath := filepath.Join(outdir, path)
-		if err := os.MkdirAll(filepath.Dir(outpath), 0777); err != nil {
-			return err
+	return writeDocs(patchedDocs, opts)
+}
+
+// applyOverlay merges overlay onto doc as a RFC 7396 JSON Merge Patch. This is the step that lets
+// a ".local" overlay file, or a batch entry's overlayGlob match, override values in doc before the
+// explicit JSON Patch in applyJSONPatch runs.
+func applyOverlay(doc, overlay []byte, docPath string) ([]byte, error) {
+	merged, err := jsonpatch.MergePatch(doc, overlay)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error applying overlay to %s", docPath)
+	}
+	return merged, nil
+}
+
+// resolveOverlay looks up the overlay content for docPath, if any. opts.overlays, populated by
+// applyBatchPatch from a batch entry's overlayGlob, takes precedence; otherwise a sibling file at
+// docPath+opts.overlaySuffix (e.g. "foo.yaml.local" next to "foo.yaml") is read if it exists. A
+// document path of "-" never has an overlay, since stdin has no sibling file.
+func resolveOverlay(docPath string, opts docOptions) ([]byte, bool, error) {
+	if opts.overlays != nil {
+		overlay, ok := opts.overlays[docPath]
+		return overlay, ok, nil
+	}
+	if docPath == "-" || opts.overlaySuffix == "" {
+		return nil, false, nil
+	}
+
+	overlayPath := docPath + opts.overlaySuffix
+	buf, err := ioutil.ReadFile(overlayPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
 		}
-		if err := ioutil.WriteFile(outpath, doc, 0644); err != nil {
-			return err
+		return nil, false, err
+	}
+
+	overlayJSON, err := toJSONIfYAML(docPath, buf, opts.format)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "error reading overlay %s", overlayPath)
+	}
+	return overlayJSON, true, nil
+}
+
+// resolveOverlayGlob resolves a batch entry's overlayGlob, which must equal glob with a literal
+// suffix appended (e.g. "config/*.yaml" and "config/*.yaml.local"). For each document in matches,
+// the overlay at that document's path plus the suffix is read if present; a document with no
+// corresponding overlay is simply omitted from the result, rather than failing the whole entry.
+func resolveOverlayGlob(matches []string, glob, overlayGlob string) (map[string][]byte, error) {
+	suffix := strings.TrimPrefix(overlayGlob, glob)
+	if suffix == "" || suffix == overlayGlob {
+		return nil, fmt.Errorf("overlayGlob %q must equal glob %q with a literal suffix appended (e.g. %q and %q)", overlayGlob, glob, "config/*.yaml", "config/*.yaml.local")
+	}
+
+	overlays := map[string][]byte{}
+	for _, docPath := range matches {
+		overlayPath := docPath + suffix
+		buf, err := ioutil.ReadFile(overlayPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		overlayJSON, err := toJSONIfYAML(docPath, buf, "")
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading overlay %s", overlayPath)
 		}
+		overlays[docPath] = overlayJSON
 	}
+	return overlays, nil
+}
 
-	return nil
+// patchOp is a single operation of a RFC 6902 JSON Patch being applied, as opposed to op, which
+// is a single operation of a JSON Patch being generated by jsonpatch diff.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
 }
 
-func readJSON(path string) ([]byte, error) {
-	buf, err := ioutil.ReadFile(path)
+// applyJSONPatchYAML applies ops directly to the YAML AST of docPath (or opts.stdin for "-"),
+// preserving comments, key order, anchors, and multi-document streams for everything the patch
+// doesn't touch.
+func applyJSONPatchYAML(ops []patchOp, docPath string, opts docOptions) ([]byte, error) {
+	var buf []byte
+	var err error
+	if docPath == "-" {
+		buf, err = ioutil.ReadAll(opts.stdin)
+	} else {
+		buf, err = ioutil.ReadFile(docPath)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if yamlExt(path) {
-		var i interface{}
-		err := yaml.Unmarshal(buf, &i)
-		if err != nil {
+	docs, err := decodeYAMLDocuments(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, doc := range docs {
+		if opts.docSelector != nil && i != *opts.docSelector {
+			continue
+		}
+		for _, o := range ops {
+			if err := applyYAMLOp(doc, o); err != nil {
+				return nil, errors.Wrapf(err, "document %d", i)
+			}
+		}
+
+		if opts.validate != nil {
+			if err := validateYAMLNode(doc, opts.validate); err != nil {
+				return nil, errors.Wrapf(err, "document %d failed schema validation", i)
+			}
+		}
+	}
+
+	return encodeYAMLDocuments(docs)
+}
+
+// validateYAMLNode decodes doc to JSON and runs validate against it, for documents handled by
+// applyJSONPatchYAML rather than the read-JSON/ApplyIndent path.
+func validateYAMLNode(doc *yamlv3.Node, validate docValidator) error {
+	var i interface{}
+	if err := doc.Decode(&i); err != nil {
+		return err
+	}
+
+	docJSON, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	return validate(docJSON)
+}
+
+// decodeYAMLDocuments splits a "---"-separated YAML stream into its constituent documents.
+func decodeYAMLDocuments(buf []byte) ([]*yamlv3.Node, error) {
+	var docs []*yamlv3.Node
+	dec := yamlv3.NewDecoder(bytes.NewReader(buf))
+	for {
+		var doc yamlv3.Node
+		if err := dec.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
 			return nil, err
 		}
+		docs = append(docs, &doc)
+	}
+	return docs, nil
+}
 
-		i, err = convert(i)
-		if err != nil {
+// encodeYAMLDocuments re-serializes docs as a "---"-separated YAML stream.
+func encodeYAMLDocuments(docs []*yamlv3.Node) ([]byte, error) {
+	for _, doc := range docs {
+		stripMergeKeyTag(doc)
+	}
+
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
 			return nil, err
 		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-		return json.Marshal(i)
+// stripMergeKeyTag clears the explicit "!!merge" tag yaml.v3 assigns to a merge key ("<<") node
+// while decoding. Left in place, the encoder writes it out as "!!merge <<: *a" instead of the
+// original "<<: *a", rewriting every merge-key line in a file the patch didn't even touch.
+func stripMergeKeyTag(n *yamlv3.Node) {
+	if n.Tag == "!!merge" {
+		n.Tag = ""
+	}
+	for _, c := range n.Content {
+		stripMergeKeyTag(c)
 	}
+}
 
-	return buf, nil
+// yamlDocRoot returns the value node at the root of a YAML DocumentNode.
+func yamlDocRoot(doc *yamlv3.Node) *yamlv3.Node {
+	if doc.Kind == yamlv3.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0]
+	}
+	return doc
 }
 
-func yamlExt(path string) bool {
-	switch filepath.Ext(path) {
-	case ".yaml", ".yml":
-		return true
+// applyYAMLOp applies a single RFC 6902 operation to doc by walking o.Path through doc's
+// Mapping/Sequence nodes and mutating only the node the path resolves to.
+func applyYAMLOp(doc *yamlv3.Node, o patchOp) error {
+	root := yamlDocRoot(doc)
+	tokens := splitPointer(o.Path)
+
+	if len(tokens) == 0 {
+		switch o.Op {
+		case "test":
+			return testYAMLNode(root, o.Value)
+		case "add", "replace":
+			n, err := valueToYAMLNode(o.Value)
+			if err != nil {
+				return err
+			}
+			*root = *n
+			return nil
+		default:
+			return fmt.Errorf("op %q is not supported at the document root", o.Op)
+		}
+	}
+
+	parent, lastTok, err := yamlWalkToParent(root, tokens)
+	if err != nil {
+		return err
+	}
+
+	switch o.Op {
+	case "test":
+		child, err := yamlChild(parent, lastTok)
+		if err != nil {
+			return err
+		}
+		return testYAMLNode(child, o.Value)
+	case "remove":
+		return yamlRemove(parent, lastTok)
+	case "add":
+		n, err := valueToYAMLNode(o.Value)
+		if err != nil {
+			return err
+		}
+		return yamlAdd(parent, lastTok, n)
+	case "replace":
+		n, err := valueToYAMLNode(o.Value)
+		if err != nil {
+			return err
+		}
+		return yamlReplace(parent, lastTok, n)
 	default:
-		return false
+		return fmt.Errorf("unsupported op %q", o.Op)
 	}
 }
 
-func convert(i interface{}) (interface{}, error) {
-	switch x := i.(type) {
-	case map[interface{}]interface{}:
-		strmap := map[string]interface{}{}
-		for k, v := range x {
-			kstr, ok := k.(string)
-			if !ok {
-				return nil, fmt.Errorf("non-string key %#v with value %#v", k, v)
+// splitPointer splits a RFC 6901 JSON Pointer into its unescaped tokens.
+func splitPointer(path string) []string {
+	if path == "" {
+		return nil
+	}
+	tokens := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, t := range tokens {
+		tokens[i] = unescapePointerToken(t)
+	}
+	return tokens
+}
+
+func yamlWalkToParent(root *yamlv3.Node, tokens []string) (*yamlv3.Node, string, error) {
+	cur := root
+	for _, tok := range tokens[:len(tokens)-1] {
+		next, err := yamlChild(cur, tok)
+		if err != nil {
+			return nil, "", err
+		}
+		cur = next
+	}
+	return cur, tokens[len(tokens)-1], nil
+}
+
+func yamlChild(node *yamlv3.Node, tok string) (*yamlv3.Node, error) {
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == tok {
+				return node.Content[i+1], nil
 			}
-			c, err := convert(v)
-			if err != nil {
-				return nil, err
+		}
+		return nil, fmt.Errorf("key %q not found", tok)
+	case yamlv3.SequenceNode:
+		idx, err := strconv.Atoi(tok)
+		if err != nil || idx < 0 || idx >= len(node.Content) {
+			return nil, fmt.Errorf("index %q out of range", tok)
+		}
+		return node.Content[idx], nil
+	default:
+		return nil, fmt.Errorf("cannot navigate into a scalar with token %q", tok)
+	}
+}
+
+func yamlRemove(parent *yamlv3.Node, tok string) error {
+	switch parent.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(parent.Content); i += 2 {
+			if parent.Content[i].Value == tok {
+				parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+				return nil
 			}
-			strmap[kstr] = c
 		}
-		return strmap, nil
-	case []interface{}:
-		for i, v := range x {
-			c, err := convert(v)
-			if err != nil {
-				return nil, err
+		return fmt.Errorf("key %q not found", tok)
+	case yamlv3.SequenceNode:
+		idx, err := strconv.Atoi(tok)
+		if err != nil || idx < 0 || idx >= len(parent.Content) {
+			return fmt.Errorf("index %q out of range", tok)
+		}
+		parent.Content = append(parent.Content[:idx], parent.Content[idx+1:]...)
+		return nil
+	default:
+		return fmt.Errorf("cannot remove from a scalar node")
+	}
+}
+
+func yamlAdd(parent *yamlv3.Node, tok string, n *yamlv3.Node) error {
+	switch parent.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(parent.Content); i += 2 {
+			if parent.Content[i].Value == tok {
+				parent.Content[i+1] = n
+				return nil
 			}
-			x[i] = c
 		}
+		key := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: tok}
+		parent.Content = append(parent.Content, key, n)
+		return nil
+	case yamlv3.SequenceNode:
+		if tok == "-" {
+			parent.Content = append(parent.Content, n)
+			return nil
+		}
+		idx, err := strconv.Atoi(tok)
+		if err != nil || idx < 0 || idx > len(parent.Content) {
+			return fmt.Errorf("index %q out of range", tok)
+		}
+		parent.Content = append(parent.Content[:idx], append([]*yamlv3.Node{n}, parent.Content[idx:]...)...)
+		return nil
+	default:
+		return fmt.Errorf("cannot add to a scalar node")
 	}
-	return i, nil
+}
+
+func yamlReplace(parent *yamlv3.Node, tok string, n *yamlv3.Node) error {
+	switch parent.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(parent.Content); i += 2 {
+			if parent.Content[i].Value == tok {
+				parent.Content[i+1] = n
+				return nil
+			}
+		}
+		return fmt.Errorf("key %q not found", tok)
+	case yamlv3.SequenceNode:
+		idx, err := strconv.Atoi(tok)
+		if err != nil || idx < 0 || idx >= len(parent.Content) {
+			return fmt.Errorf("index %q out of range", tok)
+		}
+		parent.Content[idx] = n
+		return nil
+	default:
+		return fmt.Errorf("cannot replace a scalar node's child")
+	}
+}
+
+// valueToYAMLNode builds a *yaml.Node representing raw, a JSON-encoded value. It decodes with
+// json.Decoder.UseNumber so an integer beyond float64's 53-bit mantissa (an ID, a UID, a
+// nanosecond timestamp) round-trips exactly instead of being corrupted by a float64 conversion.
+func valueToYAMLNode(raw json.RawMessage) (*yamlv3.Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return jsonValueToYAMLNode(v)
+}
+
+// jsonValueToYAMLNode builds a *yaml.Node for v, a value decoded with json.Decoder.UseNumber. A
+// json.Number is emitted as a scalar from its original token, tagged !!int or !!float depending
+// on whether the token contains a '.', 'e', or 'E', rather than round-tripped through yaml.Node.Encode, which only knows
+// about Go's native numeric kinds (and would otherwise require going through float64).
+func jsonValueToYAMLNode(v interface{}) (*yamlv3.Node, error) {
+	switch x := v.(type) {
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(x.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: tag, Value: x.String()}, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		node := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		for _, k := range keys {
+			valNode, err := jsonValueToYAMLNode(x[k])
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: k}, valNode)
+		}
+		return node, nil
+	case []interface{}:
+		node := &yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq"}
+		for _, e := range x {
+			valNode, err := jsonValueToYAMLNode(e)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, valNode)
+		}
+		return node, nil
+	default:
+		var n yamlv3.Node
+		if err := n.Encode(x); err != nil {
+			return nil, err
+		}
+		return &n, nil
+	}
+}
+
+// testYAMLNode compares node's value against raw, a JSON-encoded value, ignoring YAML-specific
+// presentation details like style and key order.
+func testYAMLNode(node *yamlv3.Node, raw json.RawMessage) error {
+	var got interface{}
+	if err := node.Decode(&got); err != nil {
+		return err
+	}
+
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		return err
+	}
+	if !jsonpatch.Equal(gotJSON, []byte(raw)) {
+		return fmt.Errorf("testing value %s failed", node.Value)
+	}
+	return nil
+}
+
+// docValidator validates a patched JSON document, returning an error with a RFC 6901 JSON Pointer
+// to the offending value when it doesn't conform.
+type docValidator func(doc []byte) error
+
+// resolveValidator builds a docValidator from the CLI's -schema or -openapi/-kind flags. It
+// returns a nil docValidator, and no error, when neither flag is set.
+func resolveValidator(schemaPath, openapiPath, kind string) (docValidator, error) {
+	switch {
+	case schemaPath != "" && openapiPath != "":
+		return nil, fmt.Errorf("-schema and -openapi are mutually exclusive")
+	case schemaPath != "":
+		schemaJSON, err := readJSON(schemaPath)
+		if err != nil {
+			return nil, err
+		}
+		return newSchemaValidator(schemaJSON)
+	case openapiPath != "":
+		if kind == "" {
+			return nil, fmt.Errorf("-openapi requires -kind")
+		}
+		openapiJSON, err := readJSON(openapiPath)
+		if err != nil {
+			return nil, err
+		}
+		return newOpenAPIValidator(openapiJSON, kind)
+	default:
+		return nil, nil
+	}
+}
+
+// newSchemaValidator builds a docValidator from a raw JSON Schema document.
+func newSchemaValidator(schemaJSON []byte) (docValidator, error) {
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schemaJSON))
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading JSON Schema")
+	}
+
+	return func(doc []byte) error {
+		result, err := schema.Validate(gojsonschema.NewBytesLoader(doc))
+		if err != nil {
+			return err
+		}
+		if result.Valid() {
+			return nil
+		}
+
+		resultErr := result.Errors()[0]
+		return fmt.Errorf("%s: %s", schemaErrorPointer(resultErr), resultErr.Description())
+	}, nil
+}
+
+// newOpenAPIValidator builds a docValidator from the named definition in a Swagger/OpenAPI v2
+// document, e.g. a Kubernetes API server's published swagger.json. kind matches either a
+// definition's full key or, more conveniently, the last "."-separated segment of its key (e.g.
+// "Deployment" matches "io.k8s.api.apps.v1.Deployment").
+func newOpenAPIValidator(openapiJSON []byte, kind string) (docValidator, error) {
+	var doc struct {
+		Definitions map[string]json.RawMessage `json:"definitions"`
+	}
+	if err := json.Unmarshal(openapiJSON, &doc); err != nil {
+		return nil, errors.Wrap(err, "error parsing OpenAPI document")
+	}
+
+	defKey, err := findDefinition(doc.Definitions, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	// definitions is included alongside the $ref so gojsonschema can resolve any internal
+	// "#/definitions/..." references the target definition makes, e.g. to a PodSpec or ObjectMeta.
+	schemaJSON, err := json.Marshal(struct {
+		Ref         string                     `json:"$ref"`
+		Definitions map[string]json.RawMessage `json:"definitions"`
+	}{
+		Ref:         "#/definitions/" + defKey,
+		Definitions: doc.Definitions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newSchemaValidator(schemaJSON)
+}
+
+// findDefinition finds the Swagger definition for kind: an exact key match, or else the unique key
+// whose last "."-separated segment equals kind.
+func findDefinition(definitions map[string]json.RawMessage, kind string) (string, error) {
+	if _, ok := definitions[kind]; ok {
+		return kind, nil
+	}
+
+	var matches []string
+	for key := range definitions {
+		segments := strings.Split(key, ".")
+		if segments[len(segments)-1] == kind {
+			matches = append(matches, key)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return "", fmt.Errorf("no definition for kind %q", kind)
+	default:
+		sort.Strings(matches)
+		return "", fmt.Errorf("kind %q is ambiguous among definitions %v", kind, matches)
+	}
+}
+
+// schemaErrorPointer converts a gojsonschema error's dotted field path (e.g. "spec.replicas", or
+// "(root)" for the document itself) to a RFC 6901 JSON Pointer (e.g. "/spec/replicas").
+func schemaErrorPointer(e gojsonschema.ResultError) string {
+	field := e.Field()
+	if field == "(root)" {
+		return "/"
+	}
+
+	var b strings.Builder
+	for _, tok := range strings.Split(field, ".") {
+		b.WriteByte('/')
+		b.WriteString(escapePointerToken(tok))
+	}
+	return b.String()
+}
+
+func applyMergePatch(patchJSON []byte, documentPaths []string, opts docOptions) error {
+	patchedDocs := map[string][]byte{}
+	for _, docPath := range documentPaths {
+		doc, err := readDocJSON(docPath, opts)
+		if err != nil {
+			return err
+		}
+
+		patchedDoc, err := jsonpatch.MergePatch(doc, patchJSON)
+		if err != nil {
+			return errors.Wrapf(err, "error applying JSON Merge Patch %s to %s", patchJSON, docPath)
+		}
+
+		if opts.validate != nil {
+			if err := opts.validate(patchedDoc); err != nil {
+				return errors.Wrapf(err, "patched %s failed schema validation", docPath)
+			}
+		}
+
+		patchedDoc, err = toOutputFormat(docPath, patchedDoc, opts.format)
+		if err != nil {
+			return err
+		}
+
+		patchedDocs[docPath] = patchedDoc
+	}
+
+	return writeDocs(patchedDocs, opts)
+}
+
+func applyStrategicPatch(patchJSON []byte, documentPaths []string, opts docOptions, t target, provider schemaProvider) error {
+	dataStruct, err := provider(t)
+	if err != nil {
+		return errors.Wrapf(err, "error resolving schema for target %+v", t)
+	}
+
+	patchedDocs := map[string][]byte{}
+	for _, docPath := range documentPaths {
+		doc, err := readDocJSON(docPath, opts)
+		if err != nil {
+			return err
+		}
+
+		patchedDoc, err := strategicpatch.StrategicMergePatch(doc, patchJSON, dataStruct)
+		if err != nil {
+			return errors.Wrapf(err, "error applying strategic merge patch %s to %s", patchJSON, docPath)
+		}
+
+		if opts.validate != nil {
+			if err := opts.validate(patchedDoc); err != nil {
+				return errors.Wrapf(err, "patched %s failed schema validation", docPath)
+			}
+		}
+
+		patchedDoc, err = toOutputFormat(docPath, patchedDoc, opts.format)
+		if err != nil {
+			return err
+		}
+
+		patchedDocs[docPath] = patchedDoc
+	}
+
+	return writeDocs(patchedDocs, opts)
+}
+
+// toOutputFormat converts a patched JSON document back to YAML when docPath has a YAML extension,
+// or when format is "yaml".
+func toOutputFormat(docPath string, patchedDoc []byte, format string) ([]byte, error) {
+	if !isYAMLFormat(docPath, format) {
+		return patchedDoc, nil
+	}
+
+	var i interface{}
+	if err := json.Unmarshal(patchedDoc, &i); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(i)
+}
+
+// docOptions controls how document inputs are read and how patched documents are emitted.
+type docOptions struct {
+	// stdin is read for a document path of "-".
+	stdin io.Reader
+
+	// stdout receives the patched result for a document path of "-".
+	stdout io.Writer
+
+	// format, when non-empty, overrides the file-extension-based YAML/JSON detection. A document
+	// path of "-" has no extension to infer from, so it is read as JSON unless format is "yaml".
+	format string
+
+	// inPlace rewrites each document atomically in place instead of writing to outdir.
+	inPlace bool
+
+	// outdir is the directory patched documents are emitted to, unless inPlace is set.
+	outdir string
+
+	// docSelector selects a single document (0-indexed) to patch within a multi-document YAML
+	// stream; nil applies the patch to every document in the stream.
+	docSelector *int
+
+	// overlaySuffix, when non-empty, enables the overlay convention for applyJSONPatch: for each
+	// document path, docPath+overlaySuffix (e.g. "foo.yaml.local" next to "foo.yaml") is read and
+	// merged onto the document, if present, before the patch runs.
+	overlaySuffix string
+
+	// overlays, when non-nil, supplies pre-resolved overlay content per document path, taking
+	// precedence over overlaySuffix. Set by applyBatchPatch to implement a batch entry's
+	// overlayGlob.
+	overlays map[string][]byte
+
+	// validate, when non-nil, is run against each patched document after the patch is applied and
+	// before the result is written.
+	validate docValidator
+}
+
+func writeDocs(docs map[string][]byte, opts docOptions) error {
+	for path, doc := range docs {
+		if path == "-" {
+			if _, err := opts.stdout.Write(doc); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if opts.inPlace {
+			if err := writeFileAtomically(path, doc); err != nil {
+				return err
+			}
+			continue
+		}
+
+		outpath := filepath.Join(opts.outdir, path)
+		if err := os.MkdirAll(filepath.Dir(outpath), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(outpath, doc, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFileAtomically writes data to a temp file alongside path and renames it over path, so a
+// reader never observes a partially written file.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func readJSON(path string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return toJSONIfYAML(path, buf, "")
+}
+
+// readDocJSON reads a document, honoring a path of "-" to mean opts.stdin.
+func readDocJSON(path string, opts docOptions) ([]byte, error) {
+	if path == "-" {
+		buf, err := ioutil.ReadAll(opts.stdin)
+		if err != nil {
+			return nil, err
+		}
+		return toJSONIfYAML(path, buf, opts.format)
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return toJSONIfYAML(path, buf, opts.format)
+}
+
+// toJSONIfYAML converts buf from YAML to JSON when isYAMLFormat(path, format), otherwise it
+// returns buf unchanged. It rejects a multi-document ("---"-separated) stream rather than
+// silently converting only its first document: unlike applyJSONPatchYAML, the merge patch,
+// strategic merge patch, and overlay code paths that call this function have no way to write more
+// than one resulting document back to a single path.
+func toJSONIfYAML(path string, buf []byte, format string) ([]byte, error) {
+	if !isYAMLFormat(path, format) {
+		return buf, nil
+	}
+
+	docs, err := decodeYAMLDocuments(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) > 1 {
+		return nil, fmt.Errorf("%s contains %d YAML documents; merge patch, strategic merge patch, and overlays support only a single document per file (use a RFC 6902 JSON Patch instead, which preserves multi-document streams)", path, len(docs))
+	}
+
+	var i interface{}
+	if err := yaml.Unmarshal(buf, &i); err != nil {
+		return nil, err
+	}
+
+	i, err = convert(i)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(i)
+}
+
+// isYAMLFormat reports whether path should be treated as YAML: format overrides the decision
+// when set, otherwise it falls back to path's file extension.
+func isYAMLFormat(path, format string) bool {
+	if format != "" {
+		return format == "yaml"
+	}
+	return yamlExt(path)
+}
+
+func yamlExt(path string) bool {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+func convert(i interface{}) (interface{}, error) {
+	switch x := i.(type) {
+	case map[interface{}]interface{}:
+		strmap := map[string]interface{}{}
+		for k, v := range x {
+			kstr, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("non-string key %#v with value %#v", k, v)
+			}
+			c, err := convert(v)
+			if err != nil {
+				return nil, err
+			}
+			strmap[kstr] = c
+		}
+		return strmap, nil
+	case []interface{}:
+		for i, v := range x {
+			c, err := convert(v)
+			if err != nil {
+				return nil, err
+			}
+			x[i] = c
+		}
+	}
+	return i, nil
+}
+
+var diffUsage = `usage: jsonpatch diff [flags] <from> <to>
+
+jsonpatch diff emits, on stdout, a patch that transforms <from> into <to>. <from> and <to> may be
+JSON or YAML documents.
+
+flags:
+  -format patch|merge
+    	the format of the generated patch: "patch" for a RFC 6902 JSON Patch, "merge" for a RFC
+    	7396 JSON Merge Patch (default "patch")
+  -with-tests
+    	prepend a "test" operation for every touched path, so the generated patch fails loudly
+    	instead of silently if <from> has since changed (only applies to -format patch)
+`
+
+// op is a single operation in a generated RFC 6902 JSON Patch. Value is only meaningful for
+// "add", "replace", and "test"; diffOps, diffObject, diffArray, and withTestOps never set it for
+// "remove".
+type op struct {
+	Op    string
+	Path  string
+	Value interface{}
+}
+
+// MarshalJSON omits the value member for "remove", the only operation that doesn't take one. A
+// bare `json:"value,omitempty"` tag can't do this: it would also drop the value member for a
+// "replace" or "add" whose target value is JSON null, producing an op that's missing a member
+// RFC 6902 requires.
+func (o op) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	return json.Marshal(struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}{o.Op, o.Path, o.Value})
+}
+
+func diffMain(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("jsonpatch diff", flag.ContinueOnError)
+	flags.SetOutput(stdout)
+	flags.Usage = func() {
+		fmt.Fprint(stdout, diffUsage)
+	}
+	format := flags.String("format", formatPatch, `the format of the generated patch: "patch" or "merge"`)
+	withTests := flags.Bool("with-tests", false, `prepend a "test" operation for every touched path`)
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 2
+		}
+		return 1
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	from, err := readDoc(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+	to, err := readDoc(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+
+	var out interface{}
+	switch *format {
+	case formatPatch:
+		ops := diffOps("", from, to)
+		if *withTests {
+			ops = withTestOps(ops, from)
+		}
+		out = ops
+	case formatMerge:
+		out = diffMerge(from, to)
+	default:
+		fmt.Fprintf(stdout, "unknown -format %q: must be %q or %q\n", *format, formatPatch, formatMerge)
+		return 1
+	}
+
+	outJSON, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+
+	fmt.Fprintln(stdout, string(outJSON))
+	return 0
+}
+
+// readDoc decodes with json.Decoder.UseNumber so a number beyond float64's 53-bit mantissa
+// survives diffOps/op.MarshalJSON using its exact original token, instead of the generated patch
+// silently diverging from to.
+func readDoc(path string) (interface{}, error) {
+	docJSON, err := readJSON(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(docJSON))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// diffOps recursively computes the RFC 6902 operations that transform from into to at path.
+func diffOps(path string, from, to interface{}) []op {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if fromIsMap && toIsMap {
+		return diffObject(path, fromMap, toMap)
+	}
+
+	fromArr, fromIsArr := from.([]interface{})
+	toArr, toIsArr := to.([]interface{})
+	if fromIsArr && toIsArr {
+		return diffArray(path, fromArr, toArr)
+	}
+
+	if reflect.DeepEqual(from, to) {
+		return nil
+	}
+	return []op{{Op: "replace", Path: path, Value: to}}
+}
+
+func diffObject(path string, from, to map[string]interface{}) []op {
+	keys := map[string]bool{}
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	var ops []op
+	for _, k := range sortedKeys {
+		childPath := path + "/" + escapePointerToken(k)
+		fromVal, inFrom := from[k]
+		toVal, inTo := to[k]
+		switch {
+		case inFrom && !inTo:
+			ops = append(ops, op{Op: "remove", Path: childPath})
+		case !inFrom && inTo:
+			ops = append(ops, op{Op: "add", Path: childPath, Value: toVal})
+		default:
+			ops = append(ops, diffOps(childPath, fromVal, toVal)...)
+		}
+	}
+	return ops
+}
+
+// diffArray emits a replace for each differing index, then add or remove operations at the
+// tail to account for any difference in length. This is not a minimal diff, but it produces a
+// correct patch without the complexity of a full LCS implementation.
+func diffArray(path string, from, to []interface{}) []op {
+	var ops []op
+	n := len(from)
+	if len(to) < n {
+		n = len(to)
+	}
+	for i := 0; i < n; i++ {
+		ops = append(ops, diffOps(fmt.Sprintf("%s/%d", path, i), from[i], to[i])...)
+	}
+
+	switch {
+	case len(to) > len(from):
+		for i := len(from); i < len(to); i++ {
+			ops = append(ops, op{Op: "add", Path: path + "/-", Value: to[i]})
+		}
+	case len(from) > len(to):
+		for i := len(from) - 1; i >= len(to); i-- {
+			ops = append(ops, op{Op: "remove", Path: fmt.Sprintf("%s/%d", path, i)})
+		}
+	}
+	return ops
+}
+
+// withTestOps prepends a "test" operation with the original value for every op whose path
+// already exists in from, so the patch fails instead of silently clobbering unexpected state.
+func withTestOps(ops []op, from interface{}) []op {
+	withTests := make([]op, 0, len(ops))
+	for _, o := range ops {
+		if o.Op != "add" {
+			if v, ok := getPointer(from, o.Path); ok {
+				withTests = append(withTests, op{Op: "test", Path: o.Path, Value: v})
+			}
+		}
+		withTests = append(withTests, o)
+	}
+	return withTests
+}
+
+// getPointer resolves the RFC 6901 JSON Pointer path against doc.
+func getPointer(doc interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return doc, true
+	}
+
+	cur := doc
+	for _, tok := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		tok = unescapePointerToken(tok)
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			val, ok := v[tok]
+			if !ok {
+				return nil, false
+			}
+			cur = val
+		case []interface{}:
+			idx, err := strconv.Atoi(tok)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil, false
+			}
+			cur = v[idx]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func escapePointerToken(tok string) string {
+	tok = strings.Replace(tok, "~", "~0", -1)
+	tok = strings.Replace(tok, "/", "~1", -1)
+	return tok
+}
+
+func unescapePointerToken(tok string) string {
+	tok = strings.Replace(tok, "~1", "/", -1)
+	tok = strings.Replace(tok, "~0", "~", -1)
+	return tok
+}
+
+// diffMerge computes a RFC 7396 JSON Merge Patch that transforms from into to.
+func diffMerge(from, to interface{}) interface{} {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if !fromIsMap || !toIsMap {
+		return to
+	}
+
+	merge := map[string]interface{}{}
+	for k, fromVal := range fromMap {
+		toVal, inTo := toMap[k]
+		switch {
+		case !inTo:
+			merge[k] = nil
+		case !reflect.DeepEqual(fromVal, toVal):
+			merge[k] = diffMerge(fromVal, toVal)
+		}
+	}
+	for k, toVal := range toMap {
+		if _, inFrom := fromMap[k]; !inFrom {
+			merge[k] = toVal
+		}
+	}
+	return merge
 }
@@ -54,12 +54,93 @@ var jsonPatch = []byte(`[
 ]`)
 
 var yamlDocument = mustMarshal(jsonToYAML(jsonDocument))
-var patchedYAMLDocument = mustMarshal(jsonToYAML(patchedJSONDocument))
 var yamlPatch = mustMarshal(jsonToYAML(jsonPatch))
 
+// patchedYAMLDocument is the expected result of applying jsonPatch to yamlDocument. Unlike
+// yamlDocument and yamlPatch, it is not derived via jsonToYAML: applyJSONPatch edits the YAML
+// AST of a .yaml/.yml document directly (see applyJSONPatchYAML), so its block-sequence
+// indentation follows gopkg.in/yaml.v3's encoder conventions rather than gopkg.in/yaml.v2's.
+var patchedYAMLDocument = []byte(`a:
+  - b: 11
+    c: 2
+  - b: 3
+  - b: 5
+    c: 6
+`)
+
+var jsonMergePatch = []byte(`{"a": null, "d": 9}`)
+
+var mergePatchedJSONDocument = []byte(`{
+	"d": 9
+}`)
+
+var overlayMergePatch = []byte(`{"d": 9}`)
+
+var patchedJSONDocumentWithOverlay = []byte(`{
+	"a": [
+		{
+			"b": 11,
+			"c": 2
+		},
+		{
+			"b": 3
+		},
+		{
+			"b": 5,
+			"c": 6
+		}
+	],
+	"d": 9
+}`)
+
+var arraySchema = []byte(`{
+	"type": "object",
+	"properties": {
+		"a": { "type": "array", "maxItems": 2 }
+	}
+}`)
+
+var swaggerDocument = []byte(`{
+	"definitions": {
+		"io.example.v1.Widget": {
+			"type": "object",
+			"properties": {
+				"a": { "type": "array", "maxItems": 2 }
+			}
+		}
+	}
+}`)
+
+var podDocument = []byte(`{
+	"spec": {
+		"containers": [
+			{ "name": "app", "image": "app:v1" },
+			{ "name": "sidecar", "image": "sidecar:v1" }
+		]
+	}
+}`)
+
+var podStrategicPatch = []byte(`{
+	"spec": {
+		"containers": [
+			{ "name": "app", "image": "app:v2" }
+		]
+	}
+}`)
+
+var patchedPodDocument = []byte(`{
+	"spec": {
+		"containers": [
+			{ "name": "app", "image": "app:v2" },
+			{ "name": "sidecar", "image": "sidecar:v1" }
+		]
+	}
+}`)
+
 func TestMain(t *testing.T) {
 	testCases := []struct {
 		args     []string
+		stdin    string
 		infiles  map[string][]byte
 		outfiles map[string][]byte
 		stdout   string
@@ -144,6 +225,245 @@ func TestMain(t *testing.T) {
 			stdout:   `error applying JSON Patch [{ "op": "test", "path": "/a/0/b", "value": 2 }] to one.json: Testing value /a/0/b failed` + "\n",
 		},
 
+		// Test RFC 7396 JSON Merge Patch, auto-detected from the patch file content.
+		{
+			args: []string{"patch.json", "one.json"},
+			infiles: map[string][]byte{
+				"patch.json": jsonMergePatch,
+				"one.json":   jsonDocument,
+			},
+			outfiles: map[string][]byte{
+				"one.json": mergePatchedJSONDocument,
+			},
+			exitCode: 0,
+		},
+		// Test RFC 7396 JSON Merge Patch with -format merge forced explicitly.
+		{
+			args: []string{"-format", "merge", "patch.json", "one.json"},
+			infiles: map[string][]byte{
+				"patch.json": jsonMergePatch,
+				"one.json":   jsonDocument,
+			},
+			outfiles: map[string][]byte{
+				"one.json": mergePatchedJSONDocument,
+			},
+			exitCode: 0,
+		},
+
+		// Test that a multi-document YAML stream is rejected by RFC 7396 JSON Merge Patch rather
+		// than silently patching only its first document.
+		{
+			args: []string{"-format", "merge", "patch.json", "one.yaml"},
+			infiles: map[string][]byte{
+				"patch.json": jsonMergePatch,
+				"one.yaml":   []byte("a: 1\n---\nb: 2\n"),
+			},
+			outfiles: map[string][]byte{},
+			exitCode: 1,
+			stdout:   `one.yaml contains 2 YAML documents; merge patch, strategic merge patch, and overlays support only a single document per file (use a RFC 6902 JSON Patch instead, which preserves multi-document streams)` + "\n",
+		},
+
+		// Test Kubernetes strategic merge patch, merging the container list by name.
+		{
+			args: []string{"-strategic", "-target-kind", "Pod", "patch.json", "pod.json"},
+			infiles: map[string][]byte{
+				"patch.json": podStrategicPatch,
+				"pod.json":   podDocument,
+			},
+			outfiles: map[string][]byte{
+				"pod.json": patchedPodDocument,
+			},
+			exitCode: 0,
+		},
+
+		// Test the .local overlay convention: one.json.local is merged onto one.json before
+		// patch.json runs.
+		{
+			args: []string{"patch.json", "one.json"},
+			infiles: map[string][]byte{
+				"patch.json":     jsonPatch,
+				"one.json":       jsonDocument,
+				"one.json.local": overlayMergePatch,
+			},
+			outfiles: map[string][]byte{
+				"one.json": patchedJSONDocumentWithOverlay,
+			},
+			exitCode: 0,
+		},
+		// Test -overlay-suffix overriding the default ".local" suffix.
+		{
+			args: []string{"-overlay-suffix", ".override", "patch.json", "one.json"},
+			infiles: map[string][]byte{
+				"patch.json":        jsonPatch,
+				"one.json":          jsonDocument,
+				"one.json.override": overlayMergePatch,
+			},
+			outfiles: map[string][]byte{
+				"one.json": patchedJSONDocumentWithOverlay,
+			},
+			exitCode: 0,
+		},
+		// Test -overlay-suffix "" disabling the convention: one.json.local is left unmerged.
+		{
+			args: []string{"-overlay-suffix", "", "patch.json", "one.json"},
+			infiles: map[string][]byte{
+				"patch.json":     jsonPatch,
+				"one.json":       jsonDocument,
+				"one.json.local": overlayMergePatch,
+			},
+			outfiles: map[string][]byte{
+				"one.json": patchedJSONDocument,
+			},
+			exitCode: 0,
+		},
+
+		// Test a batch entry's overlayGlob, pairing one.json with one.json.local.
+		{
+			args: []string{"batch.json"},
+			infiles: map[string][]byte{
+				"batch.json":     []byte(fmt.Sprintf(`[{ "glob": "one.json", "overlayGlob": "one.json.local", "jsonPatch": %s }]`, jsonPatch)),
+				"one.json":       jsonDocument,
+				"one.json.local": overlayMergePatch,
+			},
+			outfiles: map[string][]byte{
+				"one.json": patchedJSONDocumentWithOverlay,
+			},
+			exitCode: 0,
+		},
+		// Test that overlayGlob's overlay is optional per document: doc-two.json has none and is
+		// patched unmodified, rather than the whole entry failing.
+		{
+			args: []string{"batch.json"},
+			infiles: map[string][]byte{
+				"batch.json":         []byte(fmt.Sprintf(`[{ "glob": "doc-*.json", "overlayGlob": "doc-*.json.local", "jsonPatch": %s }]`, jsonPatch)),
+				"doc-one.json":       jsonDocument,
+				"doc-one.json.local": overlayMergePatch,
+				"doc-two.json":       jsonDocument,
+			},
+			outfiles: map[string][]byte{
+				"doc-one.json": patchedJSONDocumentWithOverlay,
+				"doc-two.json": patchedJSONDocument,
+			},
+			exitCode: 0,
+		},
+
+		// Test -schema accepting a document that satisfies the schema.
+		{
+			args: []string{"-schema", "schema.json", "patch.json", "one.json"},
+			infiles: map[string][]byte{
+				"patch.json": []byte(`[{ "op": "add", "path": "/a/-", "value": {"b": 5, "c": 6} }]`),
+				"schema.json": []byte(`{
+					"type": "object",
+					"properties": {
+						"a": { "type": "array", "maxItems": 3 }
+					}
+				}`),
+				"one.json": jsonDocument,
+			},
+			outfiles: map[string][]byte{
+				"one.json": []byte(`{
+					"a": [
+						{ "b": 1, "c": 2 },
+						{ "b": 3, "c": 4 },
+						{ "b": 5, "c": 6 }
+					]
+				}`),
+			},
+			exitCode: 0,
+		},
+		// Test -schema rejecting a patched document, with a JSON-Pointer error.
+		{
+			args: []string{"-schema", "schema.json", "patch.json", "one.json"},
+			infiles: map[string][]byte{
+				"patch.json":  jsonPatch,
+				"schema.json": arraySchema,
+				"one.json":    jsonDocument,
+			},
+			outfiles: map[string][]byte{},
+			exitCode: 1,
+			stdout:   `patched one.json failed schema validation: /a: Array must have at most 2 items` + "\n",
+		},
+		// Test -openapi/-kind validating against a Swagger/OpenAPI definition picked by kind.
+		{
+			args: []string{"-openapi", "swagger.json", "-kind", "Widget", "patch.json", "one.json"},
+			infiles: map[string][]byte{
+				"patch.json":   jsonPatch,
+				"swagger.json": swaggerDocument,
+				"one.json":     jsonDocument,
+			},
+			outfiles: map[string][]byte{},
+			exitCode: 1,
+			stdout:   `patched one.json failed schema validation: /a: Array must have at most 2 items` + "\n",
+		},
+
+		// Test a batch entry's schema field rejecting a patched document.
+		{
+			args: []string{"batch.json"},
+			infiles: map[string][]byte{
+				"batch.json":  []byte(fmt.Sprintf(`[{ "glob": "one.json", "schema": "schema.json", "jsonPatch": %s }]`, jsonPatch)),
+				"schema.json": arraySchema,
+				"one.json":    jsonDocument,
+			},
+			outfiles: map[string][]byte{},
+			exitCode: 1,
+			stdout:   `patched one.json failed schema validation: /a: Array must have at most 2 items` + "\n",
+		},
+
+		// Test -schema rejecting a document patched via RFC 7396 JSON Merge Patch.
+		{
+			args: []string{"-schema", "schema.json", "patch.json", "one.json"},
+			infiles: map[string][]byte{
+				"patch.json": jsonMergePatch,
+				"schema.json": []byte(`{
+					"type": "object",
+					"properties": {
+						"d": { "type": "string" }
+					}
+				}`),
+				"one.json": jsonDocument,
+			},
+			outfiles: map[string][]byte{},
+			exitCode: 1,
+			stdout:   `patched one.json failed schema validation: /d: Invalid type. Expected: string, given: integer` + "\n",
+		},
+		// Test -schema rejecting a document patched via Kubernetes strategic merge patch.
+		{
+			args: []string{"-strategic", "-target-kind", "Pod", "-schema", "schema.json", "patch.json", "pod.json"},
+			infiles: map[string][]byte{
+				"patch.json": podStrategicPatch,
+				"schema.json": []byte(`{
+					"type": "object",
+					"properties": {
+						"spec": {
+							"type": "object",
+							"properties": {
+								"containers": { "type": "array", "maxItems": 1 }
+							}
+						}
+					}
+				}`),
+				"pod.json": podDocument,
+			},
+			outfiles: map[string][]byte{},
+			exitCode: 1,
+			stdout:   `patched pod.json failed schema validation: /spec/containers: Array must have at most 1 items` + "\n",
+		},
+
+		// Test batch with a mix of jsonPatch and mergePatch entries.
+		{
+			args: []string{"batch.json"},
+			infiles: map[string][]byte{
+				"batch.json": []byte(fmt.Sprintf("[{ \"glob\": \"one.json\", \"jsonPatch\": %s }, { \"glob\": \"two.json\", \"mergePatch\": %s }]", jsonPatch, jsonMergePatch)),
+				"one.json":   jsonDocument,
+				"two.json":   jsonDocument,
+			},
+			outfiles: map[string][]byte{
+				"one.json": patchedJSONDocument,
+				"two.json": mergePatchedJSONDocument,
+			},
+			exitCode: 0,
+		},
+
 		// Test batch.
 		{
 			args: []string{"batch.json"},
@@ -216,7 +536,7 @@ func TestMain(t *testing.T) {
 			// Run jsonpatch.
 			args := append([]string{"-outdir", actualdir}, testCase.args...)
 			var stdout bytes.Buffer
-			code := testableMain(args, &stdout)
+			code := testableMain(args, strings.NewReader(testCase.stdin), &stdout)
 
 			// Check stdout.
 			if actual := stdout.String(); actual != testCase.stdout {
@@ -242,6 +562,388 @@ func TestMain(t *testing.T) {
 
 }
 
+func TestInPlace(t *testing.T) {
+	dir := mustTempDir("inplace")
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("patch.json", jsonPatch, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("one.json", jsonDocument, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	code := testableMain([]string{"-i", "patch.json", "one.json"}, strings.NewReader(""), &stdout)
+	if code != 0 {
+		t.Fatalf("expected exit code 0; got %d\nstdout:\n%s", code, stdout.String())
+	}
+
+	actual, err := ioutil.ReadFile("one.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jsonpatch.Equal(actual, patchedJSONDocument) {
+		t.Fatalf("unexpected contents of one.json after -i\n%s", actual)
+	}
+}
+
+func TestStdinStdout(t *testing.T) {
+	dir := mustTempDir("stdinstdout")
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("patch.json", jsonPatch, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	code := testableMain([]string{"patch.json", "-"}, bytes.NewReader(jsonDocument), &stdout)
+	if code != 0 {
+		t.Fatalf("expected exit code 0; got %d\nstdout:\n%s", code, stdout.String())
+	}
+
+	if !jsonpatch.Equal(stdout.Bytes(), patchedJSONDocument) {
+		t.Fatalf("unexpected stdout\n%s", stdout.String())
+	}
+}
+
+// TestYAMLLargeIntegerFidelity verifies that applyJSONPatchYAML preserves an integer beyond
+// float64's 53-bit mantissa exactly, instead of corrupting it via a float64 round-trip.
+func TestYAMLLargeIntegerFidelity(t *testing.T) {
+	dir := mustTempDir("yaml-large-int")
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("patch.json", []byte(`[{ "op": "replace", "path": "/x", "value": 1234567890123456789 }]`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("one.yaml", []byte("x: 1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	code := testableMain([]string{"-i", "patch.json", "one.yaml"}, strings.NewReader(""), &stdout)
+	if code != 0 {
+		t.Fatalf("expected exit code 0; got %d\nstdout:\n%s", code, stdout.String())
+	}
+
+	actual, err := ioutil.ReadFile("one.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "x: 1234567890123456789\n"
+	if string(actual) != expected {
+		t.Fatalf("expected %q; got %q", expected, actual)
+	}
+}
+
+// TestYAMLMergeKeyPreserved verifies that a YAML merge key ("<<: *a") survives a patch to an
+// unrelated path unchanged, instead of being rewritten as "!!merge <<: *a".
+func TestYAMLMergeKeyPreserved(t *testing.T) {
+	dir := mustTempDir("yaml-merge-key")
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("patch.json", []byte(`[{ "op": "replace", "path": "/b/y", "value": 3 }]`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := "a: &a\n  x: 1\nb:\n  <<: *a\n  y: 2\n"
+	if err := ioutil.WriteFile("one.yaml", []byte(doc), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	code := testableMain([]string{"-i", "patch.json", "one.yaml"}, strings.NewReader(""), &stdout)
+	if code != 0 {
+		t.Fatalf("expected exit code 0; got %d\nstdout:\n%s", code, stdout.String())
+	}
+
+	actual, err := ioutil.ReadFile("one.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "a: &a\n  x: 1\nb:\n  <<: *a\n  y: 3\n"
+	if string(actual) != expected {
+		t.Fatalf("expected %q; got %q", expected, actual)
+	}
+}
+
+func TestYAMLDocumentStream(t *testing.T) {
+	dir := mustTempDir("stream")
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("patch.json", jsonPatch, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	stream := []byte(`# first document
+a:
+  - b: 1
+    c: 2
+  - b: 3
+    c: 4
+---
+# second document
+a:
+  - b: 1
+    c: 2
+  - b: 3
+    c: 4
+`)
+	if err := ioutil.WriteFile("stream.yaml", stream, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// -doc 1 restricts the patch to the second document in the stream; the first document, its
+	// comment, and the separator should all survive untouched.
+	var stdout bytes.Buffer
+	code := testableMain([]string{"-doc", "1", "-i", "patch.json", "stream.yaml"}, strings.NewReader(""), &stdout)
+	if code != 0 {
+		t.Fatalf("expected exit code 0; got %d\nstdout:\n%s", code, stdout.String())
+	}
+
+	actual, err := ioutil.ReadFile("stream.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := strings.SplitN(string(actual), "---\n", 2)
+	if len(docs) != 2 {
+		t.Fatalf("expected 2 documents in stream; got %d\n%s", len(docs), actual)
+	}
+	if !strings.Contains(docs[0], "# first document") {
+		t.Fatalf("expected first document's comment to be preserved\n%s", docs[0])
+	}
+	if !strings.Contains(docs[1], "# second document") {
+		t.Fatalf("expected second document's comment to be preserved\n%s", docs[1])
+	}
+
+	firstJSON, err := yamlToJSON([]byte(docs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jsonpatch.Equal(firstJSON, jsonDocument) {
+		t.Fatalf("expected first document to be unpatched\n%s", docs[0])
+	}
+
+	secondJSON, err := yamlToJSON([]byte(docs[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jsonpatch.Equal(secondJSON, patchedJSONDocument) {
+		t.Fatalf("expected second document to be patched\n%s", docs[1])
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from := []byte(`{"a": 1, "b": {"x": 1, "y": 2}, "c": [1, 2]}`)
+	to := []byte(`{"b": {"x": 1, "y": 3}, "c": [1, 2, 3], "d": 4}`)
+
+	testCases := []struct {
+		args     []string
+		stdout   string
+		exitCode int
+	}{
+		{
+			args:     []string{"diff"},
+			stdout:   diffUsage,
+			exitCode: 2,
+		},
+		{
+			args: []string{"diff", "from.json", "to.json"},
+			stdout: `[
+  {
+    "op": "remove",
+    "path": "/a"
+  },
+  {
+    "op": "replace",
+    "path": "/b/y",
+    "value": 3
+  },
+  {
+    "op": "add",
+    "path": "/c/-",
+    "value": 3
+  },
+  {
+    "op": "add",
+    "path": "/d",
+    "value": 4
+  }
+]
+`,
+			exitCode: 0,
+		},
+		{
+			args: []string{"diff", "-format", "merge", "from.json", "to.json"},
+			stdout: `{
+  "a": null,
+  "b": {
+    "y": 3
+  },
+  "c": [
+    1,
+    2,
+    3
+  ],
+  "d": 4
+}
+`,
+			exitCode: 0,
+		},
+		{
+			args: []string{"diff", "-with-tests", "from.json", "to.json"},
+			stdout: `[
+  {
+    "op": "test",
+    "path": "/a",
+    "value": 1
+  },
+  {
+    "op": "remove",
+    "path": "/a"
+  },
+  {
+    "op": "test",
+    "path": "/b/y",
+    "value": 2
+  },
+  {
+    "op": "replace",
+    "path": "/b/y",
+    "value": 3
+  },
+  {
+    "op": "add",
+    "path": "/c/-",
+    "value": 3
+  },
+  {
+    "op": "add",
+    "path": "/d",
+    "value": 4
+  }
+]
+`,
+			exitCode: 0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(strings.Join(testCase.args, " "), func(t *testing.T) {
+			dir := mustTempDir("diff")
+			defer os.RemoveAll(dir)
+
+			if err := os.Chdir(dir); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile("from.json", from, 0666); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile("to.json", to, 0666); err != nil {
+				t.Fatal(err)
+			}
+
+			var stdout bytes.Buffer
+			code := testableMain(testCase.args, strings.NewReader(""), &stdout)
+
+			if code != testCase.exitCode {
+				t.Fatalf("expected exit code %d; got %d\nstdout:\n%s", testCase.exitCode, code, stdout.String())
+			}
+
+			if testCase.stdout != "" && testCase.stdout != stdout.String() && !jsonpatch.Equal([]byte(testCase.stdout), []byte(stdout.String())) {
+				t.Fatalf("\nexpected stdout:\n%s\ngot stdout:\n%s", testCase.stdout, stdout.String())
+			}
+		})
+	}
+}
+
+// TestDiffLargeIntegerFidelity verifies that jsonpatch diff preserves an integer beyond float64's
+// 53-bit mantissa exactly, instead of emitting a numerically-corrupted value.
+func TestDiffLargeIntegerFidelity(t *testing.T) {
+	dir := mustTempDir("diff-large-int")
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("from.json", []byte(`{"x": 1}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("to.json", []byte(`{"x": 1234567890123456789}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	code := testableMain([]string{"diff", "from.json", "to.json"}, strings.NewReader(""), &stdout)
+	if code != 0 {
+		t.Fatalf("expected exit code 0; got %d\nstdout:\n%s", code, stdout.String())
+	}
+
+	expected := `[
+  {
+    "op": "replace",
+    "path": "/x",
+    "value": 1234567890123456789
+  }
+]
+`
+	if stdout.String() != expected {
+		t.Fatalf("expected stdout:\n%s\ngot stdout:\n%s", expected, stdout.String())
+	}
+}
+
+// TestDiffNullValue verifies that a target value of JSON null is emitted as an explicit
+// "value": null member, not omitted: diff {"a":1} {"a":null} must still produce a RFC
+// 6902-compliant "replace" op, which requires a value member.
+func TestDiffNullValue(t *testing.T) {
+	dir := mustTempDir("diff-null")
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("from.json", []byte(`{"a": 1}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("to.json", []byte(`{"a": null}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	code := testableMain([]string{"diff", "from.json", "to.json"}, strings.NewReader(""), &stdout)
+	if code != 0 {
+		t.Fatalf("expected exit code 0; got %d\nstdout:\n%s", code, stdout.String())
+	}
+
+	expected := `[
+  {
+    "op": "replace",
+    "path": "/a",
+    "value": null
+  }
+]
+`
+	if stdout.String() != expected {
+		t.Fatalf("expected stdout:\n%s\ngot stdout:\n%s", expected, stdout.String())
+	}
+}
+
 func check(needle, haystack string) error {
 	return filepath.Walk(needle, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -303,5 +1005,9 @@ func yamlToJSON(y []byte) ([]byte, error) {
 	if err := yaml.Unmarshal(y, &i); err != nil {
 		return nil, err
 	}
-	return json.Marshal(i)
+	c, err := convert(i)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(c)
 }